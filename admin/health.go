@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+)
+
+// RelaySource names a single relay bootstrap URL to be health-checked.
+type RelaySource struct {
+	Relay string
+	URL   string
+}
+
+const relayProbeTimeout = 5 * time.Second
+
+// RunRelayHealthChecker periodically dials each relay URL returned by
+// sources and records the result into registry, until ctx is cancelled.
+func RunRelayHealthChecker(ctx context.Context, registry *Registry, interval time.Duration, sources func() []RelaySource) {
+	probeAll := func() {
+		for _, src := range sources() {
+			registry.RecordRelayHealth(probeRelay(src))
+		}
+	}
+
+	probeAll()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			probeAll()
+		}
+	}
+}
+
+func probeRelay(src RelaySource) RelayHealth {
+	result := RelayHealth{
+		Relay:     src.Relay,
+		URL:       src.URL,
+		CheckedAt: time.Now(),
+	}
+
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		result.LastError = err.Error()
+		return result
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "wss", "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		default:
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", host, relayProbeTimeout)
+	if err != nil {
+		result.LastError = err.Error()
+		return result
+	}
+	conn.Close()
+
+	result.Reachable = true
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result
+}
@@ -0,0 +1,270 @@
+// Package admin exposes a local HTTP API for introspecting a running
+// portal-tunnel process: per-service status, live proxied connections,
+// and relay reachability.
+package admin
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceStatus is a point-in-time snapshot of a running service tunnel.
+type ServiceStatus struct {
+	Name             string    `json:"name"`
+	LeaseID          string    `json:"leaseId"`
+	BootstrapServers []string  `json:"bootstrapServers"`
+	Protocols        []string  `json:"protocols"`
+	UpSince          time.Time `json:"upSince"`
+	BytesIn          int64     `json:"bytesIn"`
+	BytesOut         int64     `json:"bytesOut"`
+	ActiveConns      int64     `json:"activeConns"`
+	RejectedConns    int64     `json:"rejectedConns"`
+	EvictedConns     int64     `json:"evictedConns"`
+}
+
+// ConnectionInfo is a point-in-time snapshot of a single proxied
+// connection.
+type ConnectionInfo struct {
+	Service    string    `json:"service"`
+	RemoteAddr string    `json:"remoteAddr"`
+	StartedAt  time.Time `json:"startedAt"`
+	BytesIn    int64     `json:"bytesIn"`
+	BytesOut   int64     `json:"bytesOut"`
+}
+
+// RelayHealth is the latest reachability probe result for one relay
+// bootstrap URL.
+type RelayHealth struct {
+	Relay     string    `json:"relay"`
+	URL       string    `json:"url"`
+	Reachable bool      `json:"reachable"`
+	LatencyMs int64     `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+type serviceRecord struct {
+	status        ServiceStatus
+	bytesIn       int64
+	bytesOut      int64
+	activeConns   int64
+	rejectedConns int64
+	evictedConns  int64
+}
+
+type connRecord struct {
+	id         uint64
+	service    string
+	remoteAddr string
+	startedAt  time.Time
+	bytesIn    int64
+	bytesOut   int64
+}
+
+// Registry is the shared, concurrency-safe state backing the admin API.
+// runServiceTunnel and proxyConnection report into it; the admin HTTP
+// handlers read snapshots out of it.
+type Registry struct {
+	mu       sync.RWMutex
+	services map[string]*serviceRecord
+	conns    map[uint64]*connRecord
+	nextConn uint64
+
+	relayMu sync.RWMutex
+	relays  map[string]RelayHealth
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		services: make(map[string]*serviceRecord),
+		conns:    make(map[uint64]*connRecord),
+		relays:   make(map[string]RelayHealth),
+	}
+}
+
+// RegisterService records that a service tunnel has come up.
+func (r *Registry) RegisterService(name, leaseID string, bootstrapServers, protocols []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.services[name] = &serviceRecord{
+		status: ServiceStatus{
+			Name:             name,
+			LeaseID:          leaseID,
+			BootstrapServers: append([]string(nil), bootstrapServers...),
+			Protocols:        append([]string(nil), protocols...),
+			UpSince:          time.Now(),
+		},
+	}
+}
+
+// UnregisterService drops a service that has been torn down, along with
+// any connections still attributed to it.
+func (r *Registry) UnregisterService(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.services, name)
+	for id, c := range r.conns {
+		if c.service == name {
+			delete(r.conns, id)
+		}
+	}
+}
+
+// Connection is a handle returned by OpenConnection that proxyConnection
+// uses to report byte counts and signal completion.
+type Connection struct {
+	registry *Registry
+	id       uint64
+	service  string
+}
+
+// OpenConnection registers a new proxied connection for service and
+// returns a handle for reporting traffic on it. Close must be called
+// when the connection ends.
+func (r *Registry) OpenConnection(service, remoteAddr string) *Connection {
+	r.mu.Lock()
+	id := r.nextConn
+	r.nextConn++
+	r.conns[id] = &connRecord{
+		id:         id,
+		service:    service,
+		remoteAddr: remoteAddr,
+		startedAt:  time.Now(),
+	}
+	if svc, ok := r.services[service]; ok {
+		atomic.AddInt64(&svc.activeConns, 1)
+	}
+	r.mu.Unlock()
+
+	return &Connection{registry: r, id: id, service: service}
+}
+
+// AddIn reports n bytes received from the relay side.
+func (c *Connection) AddIn(n int64) {
+	if n == 0 {
+		return
+	}
+	c.registry.mu.RLock()
+	if conn, ok := c.registry.conns[c.id]; ok {
+		atomic.AddInt64(&conn.bytesIn, n)
+	}
+	if svc, ok := c.registry.services[c.service]; ok {
+		atomic.AddInt64(&svc.bytesIn, n)
+	}
+	c.registry.mu.RUnlock()
+}
+
+// AddOut reports n bytes sent to the relay side.
+func (c *Connection) AddOut(n int64) {
+	if n == 0 {
+		return
+	}
+	c.registry.mu.RLock()
+	if conn, ok := c.registry.conns[c.id]; ok {
+		atomic.AddInt64(&conn.bytesOut, n)
+	}
+	if svc, ok := c.registry.services[c.service]; ok {
+		atomic.AddInt64(&svc.bytesOut, n)
+	}
+	c.registry.mu.RUnlock()
+}
+
+// RecordRejection counts a connection that was refused before it was
+// ever opened, e.g. because MaxConcurrentConns or MaxConnsPerMinute was
+// exceeded.
+func (r *Registry) RecordRejection(service string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if svc, ok := r.services[service]; ok {
+		atomic.AddInt64(&svc.rejectedConns, 1)
+	}
+}
+
+// RecordEviction counts a connection that was forcibly closed by the
+// tunnel itself, e.g. because IdleTimeout or MaxConnDuration elapsed.
+func (r *Registry) RecordEviction(service string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if svc, ok := r.services[service]; ok {
+		atomic.AddInt64(&svc.evictedConns, 1)
+	}
+}
+
+// Close removes the connection from the registry.
+func (c *Connection) Close() {
+	r := c.registry
+	r.mu.Lock()
+	delete(r.conns, c.id)
+	if svc, ok := r.services[c.service]; ok {
+		atomic.AddInt64(&svc.activeConns, -1)
+	}
+	r.mu.Unlock()
+}
+
+// Services returns a snapshot of all registered services, sorted by name.
+func (r *Registry) Services() []ServiceStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]ServiceStatus, 0, len(r.services))
+	for _, svc := range r.services {
+		s := svc.status
+		s.BytesIn = atomic.LoadInt64(&svc.bytesIn)
+		s.BytesOut = atomic.LoadInt64(&svc.bytesOut)
+		s.ActiveConns = atomic.LoadInt64(&svc.activeConns)
+		s.RejectedConns = atomic.LoadInt64(&svc.rejectedConns)
+		s.EvictedConns = atomic.LoadInt64(&svc.evictedConns)
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Connections returns a snapshot of all currently proxied connections,
+// sorted by start time.
+func (r *Registry) Connections() []ConnectionInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]ConnectionInfo, 0, len(r.conns))
+	for _, c := range r.conns {
+		out = append(out, ConnectionInfo{
+			Service:    c.service,
+			RemoteAddr: c.remoteAddr,
+			StartedAt:  c.startedAt,
+			BytesIn:    atomic.LoadInt64(&c.bytesIn),
+			BytesOut:   atomic.LoadInt64(&c.bytesOut),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// RecordRelayHealth stores the latest probe result for a relay URL.
+func (r *Registry) RecordRelayHealth(h RelayHealth) {
+	r.relayMu.Lock()
+	defer r.relayMu.Unlock()
+	r.relays[h.Relay+"|"+h.URL] = h
+}
+
+// RelayHealthSnapshot returns a snapshot of the latest probe results, sorted by
+// relay name then URL.
+func (r *Registry) RelayHealthSnapshot() []RelayHealth {
+	r.relayMu.RLock()
+	defer r.relayMu.RUnlock()
+
+	out := make([]RelayHealth, 0, len(r.relays))
+	for _, h := range r.relays {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Relay != out[j].Relay {
+			return out[i].Relay < out[j].Relay
+		}
+		return out[i].URL < out[j].URL
+	})
+	return out
+}
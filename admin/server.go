@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server serves the admin HTTP API over a local listener.
+type Server struct {
+	registry *Registry
+	http     *http.Server
+}
+
+// NewServer builds a Server bound to addr, backed by registry. It does
+// not start listening until Start is called.
+func NewServer(addr string, registry *Registry) *Server {
+	s := &Server{registry: registry}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/connections", s.handleConnections)
+	mux.HandleFunc("/relays", s.handleRelays)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+
+	s.http = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return s
+}
+
+// Start runs the admin HTTP server until ctx is cancelled.
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("admin: listen on %s: %w", s.http.Addr, err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.http.Serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.http.Shutdown(shutdownCtx)
+		return nil
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.registry.Services())
+}
+
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.registry.Connections())
+}
+
+func (s *Server) handleRelays(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.registry.RelayHealthSnapshot())
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_service_active_connections Active proxied connections per service.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_service_active_connections gauge")
+	for _, svc := range s.registry.Services() {
+		fmt.Fprintf(w, "portal_tunnel_service_active_connections{service=%q} %d\n", svc.Name, svc.ActiveConns)
+	}
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_service_bytes_in_total Bytes received from the relay per service.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_service_bytes_in_total counter")
+	for _, svc := range s.registry.Services() {
+		fmt.Fprintf(w, "portal_tunnel_service_bytes_in_total{service=%q} %d\n", svc.Name, svc.BytesIn)
+	}
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_service_bytes_out_total Bytes sent to the relay per service.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_service_bytes_out_total counter")
+	for _, svc := range s.registry.Services() {
+		fmt.Fprintf(w, "portal_tunnel_service_bytes_out_total{service=%q} %d\n", svc.Name, svc.BytesOut)
+	}
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_service_rejected_connections_total Connections refused by a MaxConcurrentConns/MaxConnsPerMinute limit, per service.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_service_rejected_connections_total counter")
+	for _, svc := range s.registry.Services() {
+		fmt.Fprintf(w, "portal_tunnel_service_rejected_connections_total{service=%q} %d\n", svc.Name, svc.RejectedConns)
+	}
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_service_evicted_connections_total Connections closed by an IdleTimeout/MaxConnDuration limit, per service.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_service_evicted_connections_total counter")
+	for _, svc := range s.registry.Services() {
+		fmt.Fprintf(w, "portal_tunnel_service_evicted_connections_total{service=%q} %d\n", svc.Name, svc.EvictedConns)
+	}
+
+	fmt.Fprintln(w, "# HELP portal_tunnel_relay_reachable Whether the last probe of a relay URL succeeded.")
+	fmt.Fprintln(w, "# TYPE portal_tunnel_relay_reachable gauge")
+	for _, relay := range s.registry.RelayHealthSnapshot() {
+		reachable := 0
+		if relay.Reachable {
+			reachable = 1
+		}
+		fmt.Fprintf(w, "portal_tunnel_relay_reachable{relay=%q,url=%q} %d\n", relay.Relay, relay.URL, reachable)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
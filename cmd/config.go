@@ -1,51 +1,134 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 var defaultProtocols = []string{"http/1.1", "h2"}
 
+// allowedProtocols is the set of protocol strings a service may declare.
+var allowedProtocols = map[string]struct{}{
+	"http/1.1": {},
+	"h2":       {},
+	"h3":       {},
+	"tcp":      {},
+}
+
 // RelayConfig describes a named relay endpoint and its bootstrap URLs.
 type RelayConfig struct {
 	Name string   `yaml:"name"`
 	URLs []string `yaml:"urls"`
 }
 
+// TargetRoute maps one connection-time matcher to a local dial target,
+// letting a single exposed service front several local backends. Match
+// is one of "sniHost", "httpHost", "alpn", or "default".
+type TargetRoute struct {
+	Match  string `yaml:"match"`
+	Value  string `yaml:"value,omitempty"`
+	Target string `yaml:"target"`
+}
+
 // ServiceConfig describes a local service exposed through the tunnel.
 type ServiceConfig struct {
-	Name            string   `yaml:"name"`
-	RelayPreference []string `yaml:"relayPreference"`
-	Target          string   `yaml:"target"`
-	Protocols       []string `yaml:"protocols"`
+	Name            string        `yaml:"name"`
+	RelayPreference []string      `yaml:"relayPreference"`
+	Target          string        `yaml:"target"`
+	Targets         []TargetRoute `yaml:"targets,omitempty"`
+	Protocols       []string      `yaml:"protocols"`
+
+	// MaxConcurrentConns caps the number of proxied connections this
+	// service will hold open at once; 0 means unlimited.
+	MaxConcurrentConns int `yaml:"maxConcurrentConns,omitempty"`
+	// MaxConnsPerMinute caps how many new connections are accepted per
+	// minute; 0 means unlimited.
+	MaxConnsPerMinute int `yaml:"maxConnsPerMinute,omitempty"`
+	// IdleTimeout closes a proxied connection if no bytes cross it for
+	// this long in either direction; 0 means no idle timeout.
+	IdleTimeout yamlDuration `yaml:"idleTimeout,omitempty"`
+	// MaxConnDuration closes a proxied connection once it has been open
+	// this long, regardless of activity; 0 means no limit.
+	MaxConnDuration yamlDuration `yaml:"maxConnDuration,omitempty"`
 }
 
+// yamlDuration is a time.Duration that accepts either a Go duration
+// string ("30s", "2m") or a plain integer number of seconds in YAML, so
+// config authors aren't forced to remember Go's duration syntax.
+type yamlDuration time.Duration
+
+func (d yamlDuration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *yamlDuration) UnmarshalYAML(value *yaml.Node) error {
+	var secs int64
+	if err := value.Decode(&secs); err == nil {
+		*d = yamlDuration(time.Duration(secs) * time.Second)
+		return nil
+	}
+
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\" or a number of seconds")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = yamlDuration(parsed)
+	return nil
+}
+
+const (
+	matchSNIHost  = "sniHost"
+	matchHTTPHost = "httpHost"
+	matchALPN     = "alpn"
+	matchDefault  = "default"
+)
+
 // TunnelConfig represents the YAML configuration schema for portal-tunnel.
 type TunnelConfig struct {
-	Relays   []RelayConfig   `yaml:"relays"`
-	Services []ServiceConfig `yaml:"services"`
+	Relays       []RelayConfig   `yaml:"relays"`
+	Services     []ServiceConfig `yaml:"services"`
+	StrictConfig bool            `yaml:"strictConfig,omitempty"`
 }
 
 // RelayDirectory provides lookup helpers for relay definitions.
+// Entries are hot-swappable via Replace so a config reload can update
+// relay URLs without tearing down running tunnels.
 type RelayDirectory struct {
+	mu      sync.RWMutex
 	entries map[string]RelayConfig
 }
 
-// LoadConfig reads the YAML file at path, parses it into TunnelConfig, and validates it.
-func LoadConfig(path string) (*TunnelConfig, error) {
+// LoadConfig reads the YAML file at path, parses it into TunnelConfig, and
+// validates it. When strict is true (or the file itself sets
+// strictConfig: true), unknown YAML fields are rejected instead of
+// silently ignored, so typos like "relayPreferences:" fail the load.
+func LoadConfig(path string, strict bool) (*TunnelConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
 
 	var cfg TunnelConfig
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := decodeConfig(data, &cfg, strict); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	if !strict && cfg.StrictConfig {
+		if err := decodeConfig(data, &cfg, true); err != nil {
+			return nil, fmt.Errorf("parse config: %w", err)
+		}
+	}
 	cfg.applyDefaults()
 
 	if err := cfg.validate(); err != nil {
@@ -55,13 +138,33 @@ func LoadConfig(path string) (*TunnelConfig, error) {
 	return &cfg, nil
 }
 
+func decodeConfig(data []byte, cfg *TunnelConfig, strict bool) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(strict)
+	return dec.Decode(cfg)
+}
+
 // NewRelayDirectory builds a lookup structure for relay definitions.
 func NewRelayDirectory(relays []RelayConfig) *RelayDirectory {
+	return &RelayDirectory{entries: indexRelays(relays)}
+}
+
+// Replace atomically swaps the relay set, e.g. in response to a config
+// reload. Lookups in flight via BootstrapServers always see either the
+// old or the new set, never a partial one.
+func (rd *RelayDirectory) Replace(relays []RelayConfig) {
+	idx := indexRelays(relays)
+	rd.mu.Lock()
+	rd.entries = idx
+	rd.mu.Unlock()
+}
+
+func indexRelays(relays []RelayConfig) map[string]RelayConfig {
 	idx := make(map[string]RelayConfig, len(relays))
 	for _, relay := range relays {
 		idx[relay.Name] = relay
 	}
-	return &RelayDirectory{entries: idx}
+	return idx
 }
 
 // BootstrapServers aggregates URLs for the given relay preference list.
@@ -71,6 +174,9 @@ func (rd *RelayDirectory) BootstrapServers(preferences []string) ([]string, erro
 		return nil, fmt.Errorf("relayPreference must contain at least one relay name")
 	}
 
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+
 	seen := map[string]struct{}{}
 	var servers []string
 	for _, relayName := range preferences {
@@ -102,6 +208,19 @@ func (rd *RelayDirectory) BootstrapServers(preferences []string) ([]string, erro
 	return servers, nil
 }
 
+// Relays returns the relay definitions known to this directory. The
+// returned slice is a snapshot; callers must not mutate it.
+func (rd *RelayDirectory) Relays() []RelayConfig {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+
+	out := make([]RelayConfig, 0, len(rd.entries))
+	for _, relay := range rd.entries {
+		out = append(out, relay)
+	}
+	return out
+}
+
 func (cfg *TunnelConfig) validate() error {
 	var errs []string
 
@@ -125,9 +244,13 @@ func (cfg *TunnelConfig) validate() error {
 		if len(relay.URLs) == 0 {
 			errs = append(errs, fmt.Sprintf("%s: at least one url is required", prefix))
 		}
-		for j, url := range relay.URLs {
-			if strings.TrimSpace(url) == "" {
+		for j, rawURL := range relay.URLs {
+			if strings.TrimSpace(rawURL) == "" {
 				errs = append(errs, fmt.Sprintf("%s.urls[%d]: url cannot be empty", prefix, j))
+				continue
+			}
+			if err := validateRelayURL(rawURL); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.urls[%d]: %s", prefix, j, err))
 			}
 		}
 	}
@@ -141,18 +264,31 @@ func (cfg *TunnelConfig) validate() error {
 		if name == "" {
 			errs = append(errs, fmt.Sprintf("%s: name is required", prefix))
 		}
-		target := strings.TrimSpace(service.Target)
-		if target == "" {
-			errs = append(errs, fmt.Sprintf("%s: target is required", prefix))
+		if len(service.Targets) > 0 {
+			errs = append(errs, validateTargetRoutes(prefix, service.Targets)...)
+		} else if err := validateTargetAddr(service.Target); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: target %s", prefix, err))
 		}
 		for j, proto := range service.Protocols {
-			if strings.TrimSpace(proto) == "" {
-				errs = append(errs, fmt.Sprintf("%s.protocols[%d]: protocol cannot be empty", prefix, j))
+			if err := validateProtocol(proto); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.protocols[%d]: %s", prefix, j, err))
 			}
 		}
 		if len(service.RelayPreference) == 0 {
 			errs = append(errs, fmt.Sprintf("%s: relayPreference must list at least one relay name", prefix))
 		}
+		if service.MaxConcurrentConns < 0 {
+			errs = append(errs, fmt.Sprintf("%s: maxConcurrentConns cannot be negative", prefix))
+		}
+		if service.MaxConnsPerMinute < 0 {
+			errs = append(errs, fmt.Sprintf("%s: maxConnsPerMinute cannot be negative", prefix))
+		}
+		if service.IdleTimeout < 0 {
+			errs = append(errs, fmt.Sprintf("%s: idleTimeout cannot be negative", prefix))
+		}
+		if service.MaxConnDuration < 0 {
+			errs = append(errs, fmt.Sprintf("%s: maxConnDuration cannot be negative", prefix))
+		}
 		for j, relayName := range service.RelayPreference {
 			relayName = strings.TrimSpace(relayName)
 			if relayName == "" {
@@ -172,6 +308,98 @@ func (cfg *TunnelConfig) validate() error {
 	return nil
 }
 
+// validateTargetRoutes checks that a routed service has exactly one
+// default target and that no two routes match the same matcher/value
+// pair.
+func validateTargetRoutes(prefix string, routes []TargetRoute) []string {
+	var errs []string
+
+	hasDefault := false
+	seen := map[string]struct{}{}
+	for j, route := range routes {
+		rp := fmt.Sprintf("%s.targets[%d]", prefix, j)
+		match := strings.TrimSpace(route.Match)
+		switch match {
+		case matchSNIHost, matchHTTPHost, matchALPN:
+			if strings.TrimSpace(route.Value) == "" {
+				errs = append(errs, fmt.Sprintf("%s: value is required for match %q", rp, match))
+			}
+		case matchDefault:
+			if hasDefault {
+				errs = append(errs, fmt.Sprintf("%s: duplicate default target", rp))
+			}
+			hasDefault = true
+		case "":
+			errs = append(errs, fmt.Sprintf("%s: match is required", rp))
+		default:
+			errs = append(errs, fmt.Sprintf("%s: unknown match %q (want sniHost, httpHost, alpn, or default)", rp, match))
+		}
+
+		if err := validateTargetAddr(route.Target); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: target %s", rp, err))
+		}
+
+		key := match + "|" + route.Value
+		if _, exists := seen[key]; exists {
+			errs = append(errs, fmt.Sprintf("%s: matcher %q collides with another route", rp, key))
+		}
+		seen[key] = struct{}{}
+	}
+
+	if !hasDefault {
+		errs = append(errs, fmt.Sprintf("%s: a default target is required when targets is set", prefix))
+	}
+
+	return errs
+}
+
+// validateTargetAddr requires addr to be a non-empty host:port pair.
+func validateTargetAddr(addr string) error {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return fmt.Errorf("is required")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("must be a host:port address: %w", err)
+	}
+	if host == "" {
+		return fmt.Errorf("must include a host")
+	}
+	if port == "" {
+		return fmt.Errorf("must include a port")
+	}
+	return nil
+}
+
+// validateProtocol checks proto against the allow-list of protocol
+// strings the SDK understands.
+func validateProtocol(proto string) error {
+	proto = strings.TrimSpace(proto)
+	if proto == "" {
+		return fmt.Errorf("protocol cannot be empty")
+	}
+	if _, ok := allowedProtocols[proto]; !ok {
+		return fmt.Errorf("unknown protocol %q (want http/1.1, h2, h3, or tcp)", proto)
+	}
+	return nil
+}
+
+// validateRelayURL requires rawURL to parse as a ws:// or wss:// URL.
+func validateRelayURL(rawURL string) error {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("must use the ws:// or wss:// scheme, got %q", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("must include a host, got %q", rawURL)
+	}
+	return nil
+}
+
 func (cfg *TunnelConfig) applyDefaults() {
 	for i := range cfg.Services {
 		applyServiceDefaults(&cfg.Services[i])
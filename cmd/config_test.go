@@ -0,0 +1,125 @@
+package main
+
+import "testing"
+
+func validConfig() *TunnelConfig {
+	return &TunnelConfig{
+		Relays: []RelayConfig{
+			{Name: "primary", URLs: []string{"wss://relay.example.com/ws"}},
+		},
+		Services: []ServiceConfig{
+			{
+				Name:            "web",
+				Target:          "127.0.0.1:8080",
+				Protocols:       []string{"http/1.1"},
+				RelayPreference: []string{"primary"},
+			},
+		},
+	}
+}
+
+func TestTunnelConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(cfg *TunnelConfig)
+		wantErr bool
+	}{
+		{
+			name:   "valid config passes",
+			mutate: func(cfg *TunnelConfig) {},
+		},
+		{
+			name:    "no relays",
+			mutate:  func(cfg *TunnelConfig) { cfg.Relays = nil },
+			wantErr: true,
+		},
+		{
+			name:    "no services",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services = nil },
+			wantErr: true,
+		},
+		{
+			name:    "duplicate relay name",
+			mutate:  func(cfg *TunnelConfig) { cfg.Relays = append(cfg.Relays, cfg.Relays[0]) },
+			wantErr: true,
+		},
+		{
+			name:    "relay with no urls",
+			mutate:  func(cfg *TunnelConfig) { cfg.Relays[0].URLs = nil },
+			wantErr: true,
+		},
+		{
+			name:    "relay url with wrong scheme",
+			mutate:  func(cfg *TunnelConfig) { cfg.Relays[0].URLs = []string{"https://relay.example.com"} },
+			wantErr: true,
+		},
+		{
+			name:    "service missing target",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services[0].Target = "" },
+			wantErr: true,
+		},
+		{
+			name:    "service target missing port",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services[0].Target = "127.0.0.1" },
+			wantErr: true,
+		},
+		{
+			name:    "service referencing unknown relay",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services[0].RelayPreference = []string{"missing"} },
+			wantErr: true,
+		},
+		{
+			name:    "service with unknown protocol",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services[0].Protocols = []string{"gopher"} },
+			wantErr: true,
+		},
+		{
+			name:    "negative maxConcurrentConns",
+			mutate:  func(cfg *TunnelConfig) { cfg.Services[0].MaxConcurrentConns = -1 },
+			wantErr: true,
+		},
+		{
+			name: "routed service with valid targets and default",
+			mutate: func(cfg *TunnelConfig) {
+				cfg.Services[0].Target = ""
+				cfg.Services[0].Targets = []TargetRoute{
+					{Match: matchSNIHost, Value: "a.example.com", Target: "127.0.0.1:9001"},
+					{Match: matchDefault, Target: "127.0.0.1:9000"},
+				}
+			},
+		},
+		{
+			name: "routed service missing default target",
+			mutate: func(cfg *TunnelConfig) {
+				cfg.Services[0].Target = ""
+				cfg.Services[0].Targets = []TargetRoute{
+					{Match: matchSNIHost, Value: "a.example.com", Target: "127.0.0.1:9001"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "routed service with colliding matchers",
+			mutate: func(cfg *TunnelConfig) {
+				cfg.Services[0].Target = ""
+				cfg.Services[0].Targets = []TargetRoute{
+					{Match: matchSNIHost, Value: "a.example.com", Target: "127.0.0.1:9001"},
+					{Match: matchSNIHost, Value: "a.example.com", Target: "127.0.0.1:9002"},
+					{Match: matchDefault, Target: "127.0.0.1:9000"},
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(cfg)
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
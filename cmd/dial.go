@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// localDialFunc opens the "local" side of a proxied connection. addr is
+// the resolved Target/TargetRoute for ordinary services; remoteAddr is
+// the relay-side peer, passed through so non-TCP dialers (e.g. the sshd
+// mode's reverse-forward channel) can report an accurate originator.
+type localDialFunc func(ctx context.Context, addr string, remoteAddr net.Addr) (net.Conn, error)
+
+// dialTCP is the default localDialFunc used by the config and flags
+// expose modes: it dials addr directly over TCP.
+func dialTCP(ctx context.Context, addr string, remoteAddr net.Addr) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
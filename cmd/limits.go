@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// connGate gates a service's accept loop behind a concurrency semaphore
+// and a per-minute token bucket, both sized from ServiceConfig, so a
+// misbehaving relay peer cannot exhaust local backend sockets. Either
+// limit can be disabled by leaving its config field at zero.
+type connGate struct {
+	sem     chan struct{}
+	limiter *rateLimiter
+}
+
+// newConnGate builds a connGate for service. Limits that are zero in the
+// config are left unenforced.
+func newConnGate(service *ServiceConfig) *connGate {
+	g := &connGate{}
+	if service.MaxConcurrentConns > 0 {
+		g.sem = make(chan struct{}, service.MaxConcurrentConns)
+	}
+	if service.MaxConnsPerMinute > 0 {
+		g.limiter = newRateLimiter(service.MaxConnsPerMinute)
+	}
+	return g
+}
+
+// tryAcquire reports whether a new connection may proceed. It never
+// blocks: callers reject the connection immediately on false.
+func (g *connGate) tryAcquire() bool {
+	if g.limiter != nil && !g.limiter.Allow() {
+		return false
+	}
+	if g.sem == nil {
+		return true
+	}
+	select {
+	case g.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a slot acquired via tryAcquire. It must be called
+// exactly once for every successful tryAcquire.
+func (g *connGate) release() {
+	if g.sem != nil {
+		<-g.sem
+	}
+}
+
+// rateLimiter is a token bucket refilled to max once per minute. It is
+// intentionally simple (a fixed per-minute window rather than a smooth
+// refill rate) since the config knob it backs is itself expressed as a
+// per-minute count.
+type rateLimiter struct {
+	mu         sync.Mutex
+	tokens     int
+	max        int
+	refilledAt time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{tokens: perMinute, max: perMinute, refilledAt: time.Now()}
+}
+
+// Allow reports whether a token is available and, if so, consumes it.
+func (r *rateLimiter) Allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.refilledAt) >= time.Minute {
+		r.tokens = r.max
+		r.refilledAt = time.Now()
+	}
+	if r.tokens <= 0 {
+		return false
+	}
+	r.tokens--
+	return true
+}
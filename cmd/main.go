@@ -11,20 +11,35 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"gosuda.org/portal-tunnel/admin"
 	"gosuda.org/portal/sdk"
 	"gosuda.org/portal/utils"
 )
 
 var (
-	flagConfigPath string
-	flagRelayURLs  string
-	flagHost       string
-	flagPort       string
-	flagName       string
+	flagConfigPath   string
+	flagRelayURLs    string
+	flagHost         string
+	flagPort         string
+	flagName         string
+	flagAdminAddr    string
+	flagStrictConfig bool
+
+	flagSSHDAddr           string
+	flagSSHDHostKey        string
+	flagSSHDAuthorizedKeys string
 )
 
+// adminRegistry is shared by every expose mode (config, flags, and the
+// sshd-driven mode). Only the expose command serves it, through
+// --admin-addr; the sshd command starts no admin server.
+var adminRegistry = admin.NewRegistry()
+
+const relayHealthCheckInterval = 30 * time.Second
+
 func main() {
 	if len(os.Args) < 2 {
 		printTunnelUsage()
@@ -39,11 +54,24 @@ func main() {
 		fs.StringVar(&flagHost, "host", "localhost", "Local host to proxy to when config is not provided")
 		fs.StringVar(&flagPort, "port", "4018", "Local port to proxy to when config is not provided")
 		fs.StringVar(&flagName, "name", "", "Service name when config is not provided (auto-generated if empty)")
+		fs.StringVar(&flagAdminAddr, "admin-addr", "", "Address to serve the local admin HTTP API on (disabled if empty)")
+		fs.BoolVar(&flagStrictConfig, "strict-config", false, "Reject unknown fields in the config file instead of ignoring them")
 		_ = fs.Parse(os.Args[2:])
 
 		if err := runExpose(); err != nil {
 			log.Fatal().Err(err).Msg("Failed to expose")
 		}
+	case "sshd":
+		fs := flag.NewFlagSet("sshd", flag.ExitOnError)
+		fs.StringVar(&flagSSHDAddr, "addr", ":2222", "Address for the embedded SSH server to listen on")
+		fs.StringVar(&flagSSHDHostKey, "host-key", "", "Path to an SSH host private key (generated in-memory if empty)")
+		fs.StringVar(&flagSSHDAuthorizedKeys, "authorized-keys", "", "Path to an authorized_keys file (required)")
+		fs.StringVar(&flagRelayURLs, "relay", "ws://localhost:4017/relay", "Portal relay server URLs forwarded tunnels are exposed through (comma-separated)")
+		_ = fs.Parse(os.Args[2:])
+
+		if err := runSSHD(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to run sshd")
+		}
 	case "-h", "--help", "help":
 		printTunnelUsage()
 	default:
@@ -57,8 +85,9 @@ func printTunnelUsage() {
 	fmt.Println("portal-tunnel — Expose local services through Portal relay")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  portal-tunnel expose --config <file>")
-	fmt.Println("  portal-tunnel expose [--relay URL1,URL2] [--host HOST] [--port PORT] [--name NAME]")
+	fmt.Println("  portal-tunnel expose --config <file> [--admin-addr HOST:PORT] [--strict-config]")
+	fmt.Println("  portal-tunnel expose [--relay URL1,URL2] [--host HOST] [--port PORT] [--name NAME] [--admin-addr HOST:PORT]")
+	fmt.Println("  portal-tunnel sshd --authorized-keys <file> [--addr HOST:PORT] [--relay URL1,URL2]")
 }
 
 func runExpose() error {
@@ -69,7 +98,7 @@ func runExpose() error {
 }
 
 func runExposeWithConfig() error {
-	cfg, err := LoadConfig(flagConfigPath)
+	cfg, err := LoadConfig(flagConfigPath, flagStrictConfig)
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
@@ -87,38 +116,14 @@ func runExposeWithConfig() error {
 		cancel()
 	}()
 
-	errCh := make(chan error, len(cfg.Services))
-	var wg sync.WaitGroup
+	startAdminServer(ctx, relayDir)
 
-	for i := range cfg.Services {
-		service := &cfg.Services[i]
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := runServiceTunnel(ctx, relayDir, service, fmt.Sprintf("config=%s", flagConfigPath)); err != nil {
-				errCh <- err
-			}
-		}()
-	}
-
-	doneCh := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(doneCh)
-	}()
-
-	select {
-	case err := <-errCh:
-		cancel()
-		<-doneCh
+	if err := runExposeWithReload(ctx, cfg, relayDir); err != nil {
 		return err
-	case <-ctx.Done():
-		<-doneCh
-		log.Info().Msg("Tunnel stopped")
-		return nil
-	case <-doneCh:
-		return nil
 	}
+
+	log.Info().Msg("Tunnel stopped")
+	return nil
 }
 
 func runExposeWithFlags() error {
@@ -154,7 +159,9 @@ func runExposeWithFlags() error {
 		cancel()
 	}()
 
-	if err := runServiceTunnel(ctx, relayDir, service, "flags"); err != nil {
+	startAdminServer(ctx, relayDir)
+
+	if err := runServiceTunnel(ctx, relayDir, service, "flags", dialTCP); err != nil {
 		return err
 	}
 
@@ -162,20 +169,92 @@ func runExposeWithFlags() error {
 	return nil
 }
 
-func proxyConnection(ctx context.Context, localAddr string, relayConn net.Conn) error {
+// startAdminServer starts the admin HTTP API and the relay health
+// checker when --admin-addr is set; it is a no-op otherwise.
+func startAdminServer(ctx context.Context, relayDir *RelayDirectory) {
+	if strings.TrimSpace(flagAdminAddr) == "" {
+		return
+	}
+
+	server := admin.NewServer(flagAdminAddr, adminRegistry)
+	go func() {
+		if err := server.Start(ctx); err != nil {
+			log.Error().Err(err).Msg("Admin API server exited with error")
+		}
+	}()
+	log.Info().Str("addr", flagAdminAddr).Msg("Admin API listening")
+
+	go admin.RunRelayHealthChecker(ctx, adminRegistry, relayHealthCheckInterval, func() []admin.RelaySource {
+		relays := relayDir.Relays()
+		sources := make([]admin.RelaySource, 0, len(relays))
+		for _, relay := range relays {
+			for _, url := range relay.URLs {
+				sources = append(sources, admin.RelaySource{Relay: relay.Name, URL: url})
+			}
+		}
+		return sources
+	})
+}
+
+// countingReader wraps an io.Reader and reports every successful read to
+// the admin registry so /status and /metrics can show live byte counts.
+type countingReader struct {
+	r      io.Reader
+	report func(int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.report(int64(n))
+	}
+	return n, err
+}
+
+func proxyConnection(ctx context.Context, service *ServiceConfig, serviceName string, relayConn net.Conn, dial localDialFunc) error {
 	defer relayConn.Close()
 
-	localConn, err := net.Dial("tcp", localAddr)
+	peerAddr := relayConn.RemoteAddr()
+	localAddr, relayConn, err := resolveTarget(service, relayConn)
+	if err != nil {
+		return err
+	}
+
+	connCtx := ctx
+	if d := service.MaxConnDuration.Duration(); d > 0 {
+		var cancel context.CancelFunc
+		connCtx, cancel = context.WithTimeout(connCtx, d)
+		defer cancel()
+	}
+
+	var idleTimer *time.Timer
+	var idleCancel context.CancelFunc
+	if d := service.IdleTimeout.Duration(); d > 0 {
+		connCtx, idleCancel = context.WithCancel(connCtx)
+		defer idleCancel()
+		idleTimer = time.AfterFunc(d, idleCancel)
+		defer idleTimer.Stop()
+	}
+	touch := func(int64) {
+		if idleTimer != nil {
+			idleTimer.Reset(service.IdleTimeout.Duration())
+		}
+	}
+
+	localConn, err := dial(connCtx, localAddr, peerAddr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to local service %s: %w", localAddr, err)
 	}
 	defer localConn.Close()
 
+	conn := adminRegistry.OpenConnection(serviceName, peerAddr.String())
+	defer conn.Close()
+
 	errCh := make(chan error, 2)
 	stopCh := make(chan struct{})
 	go func() {
 		select {
-		case <-ctx.Done():
+		case <-connCtx.Done():
 			relayConn.Close()
 			localConn.Close()
 		case <-stopCh:
@@ -183,12 +262,12 @@ func proxyConnection(ctx context.Context, localAddr string, relayConn net.Conn)
 	}()
 
 	go func() {
-		_, err := io.Copy(localConn, relayConn)
+		_, err := io.Copy(localConn, &countingReader{relayConn, func(n int64) { conn.AddIn(n); touch(n) }})
 		errCh <- err
 	}()
 
 	go func() {
-		_, err := io.Copy(relayConn, localConn)
+		_, err := io.Copy(relayConn, &countingReader{localConn, func(n int64) { conn.AddOut(n); touch(n) }})
 		errCh <- err
 	}()
 
@@ -197,11 +276,14 @@ func proxyConnection(ctx context.Context, localAddr string, relayConn net.Conn)
 	relayConn.Close()
 	<-errCh
 
+	if connCtx.Err() != nil && ctx.Err() == nil {
+		adminRegistry.RecordEviction(serviceName)
+	}
+
 	return err
 }
 
-func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *ServiceConfig, origin string) error {
-	localAddr := service.Target
+func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *ServiceConfig, origin string, dial localDialFunc) error {
 	serviceName := strings.TrimSpace(service.Name)
 	bootstrapServers, err := relayDir.BootstrapServers(service.RelayPreference)
 	if err != nil {
@@ -214,9 +296,9 @@ func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *Se
 		serviceName = fmt.Sprintf("tunnel-%s", leaseID[:8])
 		log.Info().Str("service", serviceName).Msg("No service name provided; generated automatically")
 	}
-	log.Info().Str("service", serviceName).Msgf("Local service is reachable at %s", localAddr)
+	log.Info().Str("service", serviceName).Msgf("Local service is reachable at %s", describeTargets(service))
 	log.Info().Str("service", serviceName).Msgf("Starting Portal Tunnel (%s)...", origin)
-	log.Info().Str("service", serviceName).Msgf("  Local:    %s", localAddr)
+	log.Info().Str("service", serviceName).Msgf("  Local:    %s", describeTargets(service))
 	log.Info().Str("service", serviceName).Msgf("  Relays:   %s", strings.Join(bootstrapServers, ", "))
 	log.Info().Str("service", serviceName).Msgf("  Lease ID: %s", leaseID)
 
@@ -234,6 +316,9 @@ func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *Se
 	}
 	defer listener.Close()
 
+	adminRegistry.RegisterService(serviceName, leaseID, bootstrapServers, service.Protocols)
+	defer adminRegistry.UnregisterService(serviceName)
+
 	go func() {
 		<-ctx.Done()
 		_ = listener.Close()
@@ -247,6 +332,8 @@ func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *Se
 
 	log.Info().Str("service", serviceName).Msg("")
 
+	gate := newConnGate(service)
+
 	connCount := 0
 	var connWG sync.WaitGroup
 	defer connWG.Wait()
@@ -268,13 +355,21 @@ func runServiceTunnel(ctx context.Context, relayDir *RelayDirectory, service *Se
 			}
 		}
 
+		if !gate.tryAcquire() {
+			log.Warn().Str("service", serviceName).Msgf("Rejecting connection from %s: concurrency or rate limit exceeded", relayConn.RemoteAddr())
+			adminRegistry.RecordRejection(serviceName)
+			relayConn.Close()
+			continue
+		}
+
 		connCount++
 		log.Info().Str("service", serviceName).Msgf("→ [#%d] New connection from %s", connCount, relayConn.RemoteAddr())
 
 		connWG.Add(1)
 		go func(relayConn net.Conn) {
 			defer connWG.Done()
-			if err := proxyConnection(ctx, localAddr, relayConn); err != nil {
+			defer gate.release()
+			if err := proxyConnection(ctx, service, serviceName, relayConn, dial); err != nil {
 				log.Error().Str("service", serviceName).Err(err).Msg("Proxy error")
 			}
 			log.Info().Str("service", serviceName).Msg("Connection closed")
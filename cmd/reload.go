@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// restartBackoffInitial and restartBackoffMax bound the delay between
+// automatic restarts of a service tunnel whose goroutine exits on its
+// own (startup failure, relay disconnect, etc.) rather than because the
+// reconciler stopped it. The delay doubles on each consecutive failure
+// and resets once a tunnel has stayed up for at least restartBackoffMax,
+// so a service that is merely flaky doesn't get stuck at the max delay.
+const (
+	restartBackoffInitial = 1 * time.Second
+	restartBackoffMax     = 30 * time.Second
+)
+
+// serviceSupervisor tracks a single running service tunnel so it can be
+// torn down independently of its siblings when a config reload removes
+// or changes it.
+type serviceSupervisor struct {
+	config ServiceConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// reconciler owns the set of running service tunnels and keeps it in
+// sync with the config file on disk, restarting only the services whose
+// definition actually changed.
+type reconciler struct {
+	configPath string
+	relayDir   *RelayDirectory
+
+	mu          sync.Mutex
+	supervisors map[string]*serviceSupervisor
+}
+
+// runExposeWithReload starts a tunnel per service in cfg, and then
+// watches the config file (and SIGHUP) for changes, reconciling the
+// running set on every reload without disturbing unchanged services.
+func runExposeWithReload(ctx context.Context, cfg *TunnelConfig, relayDir *RelayDirectory) error {
+	r := &reconciler{
+		configPath:  flagConfigPath,
+		relayDir:    relayDir,
+		supervisors: make(map[string]*serviceSupervisor),
+	}
+	r.reconcile(ctx, cfg)
+
+	reloadCh, stopWatch, err := watchReloadEvents(flagConfigPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("Config hot reload disabled: failed to start watcher")
+	} else {
+		defer stopWatch()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.shutdownAll()
+			return nil
+		case <-reloadCh:
+			r.reload(ctx)
+		}
+	}
+}
+
+// watchReloadEvents merges filesystem change notifications for path with
+// SIGHUP so either trigger causes a reconcile pass.
+func watchReloadEvents(path string) (<-chan struct{}, func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, nil, fmt.Errorf("watch %s: %w", path, err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	out := make(chan struct{}, 1)
+	stop := make(chan struct{})
+
+	notify := func() {
+		select {
+		case out <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(path) {
+					notify()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Msg("Config watcher error")
+			case <-sigCh:
+				log.Info().Msg("Received SIGHUP, reloading config")
+				notify()
+			}
+		}
+	}()
+
+	return out, func() {
+		close(stop)
+		signal.Stop(sigCh)
+		watcher.Close()
+	}, nil
+}
+
+func (r *reconciler) reload(ctx context.Context) {
+	cfg, err := LoadConfig(r.configPath, flagStrictConfig)
+	if err != nil {
+		log.Error().Err(err).Msg("Config reload failed; keeping previous configuration")
+		return
+	}
+	r.relayDir.Replace(cfg.Relays)
+	r.reconcile(ctx, cfg)
+}
+
+// reconcile diffs the desired service set against what is currently
+// running: services that are new are started, services that were
+// removed are stopped, services whose target/routes/relayPreference/
+// protocols or connection limits changed are restarted, and everything
+// else is left untouched.
+func (r *reconciler) reconcile(ctx context.Context, cfg *TunnelConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	desired := make(map[string]ServiceConfig, len(cfg.Services))
+	for i := range cfg.Services {
+		desired[cfg.Services[i].Name] = cfg.Services[i]
+	}
+
+	for name, sup := range r.supervisors {
+		svc, stillWanted := desired[name]
+		switch {
+		case !stillWanted:
+			log.Info().Str("service", name).Msg("Service removed; stopping tunnel")
+			r.stopLocked(name, sup)
+		case !serviceRuntimeEqual(sup.config, svc):
+			log.Info().Str("service", name).Msg("Service changed; restarting tunnel")
+			r.stopLocked(name, sup)
+			r.startLocked(ctx, svc)
+		}
+	}
+
+	for name, svc := range desired {
+		if _, running := r.supervisors[name]; !running {
+			log.Info().Str("service", name).Msg("Service added; starting tunnel")
+			r.startLocked(ctx, svc)
+		}
+	}
+}
+
+// startLocked launches svc's tunnel goroutine, which keeps itself alive:
+// if runServiceTunnel returns for any reason other than svcCtx being
+// cancelled (a bad relay, a name collision, a dropped relay connection,
+// ...), it is restarted with an exponential backoff instead of leaving
+// the service down until an unrelated config change happens to touch it.
+func (r *reconciler) startLocked(parent context.Context, svc ServiceConfig) {
+	svcCtx, cancel := context.WithCancel(parent)
+	sup := &serviceSupervisor{
+		config: svc,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	r.supervisors[svc.Name] = sup
+
+	service := svc
+	go func() {
+		defer close(sup.done)
+
+		backoff := restartBackoffInitial
+		for {
+			start := time.Now()
+			err := runServiceTunnel(svcCtx, r.relayDir, &service, fmt.Sprintf("config=%s", r.configPath), dialTCP)
+			if svcCtx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Error().Str("service", svc.Name).Err(err).Msg("Service tunnel exited with error")
+			} else {
+				log.Warn().Str("service", svc.Name).Msg("Service tunnel exited unexpectedly")
+			}
+			if time.Since(start) >= restartBackoffMax {
+				backoff = restartBackoffInitial
+			}
+
+			log.Info().Str("service", svc.Name).Dur("backoff", backoff).Msg("Restarting service tunnel")
+			select {
+			case <-svcCtx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > restartBackoffMax {
+				backoff = restartBackoffMax
+			}
+		}
+	}()
+}
+
+// stopLocked cancels a supervised tunnel and waits for it to finish so
+// its listener is fully released before a replacement is started.
+func (r *reconciler) stopLocked(name string, sup *serviceSupervisor) {
+	sup.cancel()
+	<-sup.done
+	delete(r.supervisors, name)
+}
+
+func (r *reconciler) shutdownAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, sup := range r.supervisors {
+		r.stopLocked(name, sup)
+	}
+}
+
+// serviceRuntimeEqual reports whether two service definitions are
+// equivalent from the tunnel's point of view, i.e. whether a running
+// tunnel for a can keep serving b without being restarted.
+func serviceRuntimeEqual(a, b ServiceConfig) bool {
+	if a.Target != b.Target {
+		return false
+	}
+	if !targetRoutesEqual(a.Targets, b.Targets) {
+		return false
+	}
+	if !stringSliceEqual(a.RelayPreference, b.RelayPreference) {
+		return false
+	}
+	if !stringSliceEqual(a.Protocols, b.Protocols) {
+		return false
+	}
+	if a.MaxConcurrentConns != b.MaxConcurrentConns {
+		return false
+	}
+	if a.MaxConnsPerMinute != b.MaxConnsPerMinute {
+		return false
+	}
+	if a.IdleTimeout != b.IdleTimeout {
+		return false
+	}
+	if a.MaxConnDuration != b.MaxConnDuration {
+		return false
+	}
+	return true
+}
+
+// targetRoutesEqual reports whether two route lists are equivalent,
+// including match order (routes are evaluated in order, so a reorder
+// can change which one wins).
+func targetRoutesEqual(a, b []TargetRoute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// peekBufferSize bounds how many bytes of the relay-side connection we
+// buffer while sniffing for a routing matcher. A ClientHello or HTTP
+// request line rarely needs more than a few KB.
+const peekBufferSize = 8192
+
+// peekDeadline bounds how long we wait for enough bytes to sniff a
+// route before giving up and falling back to the default target. A
+// full ClientHello or HTTP request line normally arrives in one flight,
+// so this only matters for malformed or deliberately slow clients.
+const peekDeadline = 3 * time.Second
+
+// peekedConn wraps a net.Conn so the bytes consumed while sniffing for a
+// route are replayed to whatever reads from it afterwards. Only Peek is
+// ever used for sniffing, so nothing the matcher inspects is actually
+// drained from br; Read simply continues pulling from the same buffered
+// reader.
+type peekedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func newPeekedConn(c net.Conn) *peekedConn {
+	return &peekedConn{Conn: c, br: bufio.NewReaderSize(c, peekBufferSize)}
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+// resolveTarget picks the local dial address for an incoming relay
+// connection. Services without Targets use the legacy single Target
+// field directly. Routed services are peeked for SNI/ALPN (TLS) or a
+// Host header (plaintext HTTP/1.1) and matched against service.Targets,
+// falling back to the default route.
+func resolveTarget(service *ServiceConfig, relayConn net.Conn) (string, net.Conn, error) {
+	if len(service.Targets) == 0 {
+		return service.Target, relayConn, nil
+	}
+
+	conn := newPeekedConn(relayConn)
+	_ = relayConn.SetReadDeadline(time.Now().Add(peekDeadline))
+	defer relayConn.SetReadDeadline(time.Time{})
+
+	first, _ := conn.br.Peek(1)
+
+	var sniHost string
+	var alpns []string
+	var httpHost string
+
+	switch {
+	case len(first) == 0:
+		// Nothing arrived before the deadline; fall through to the
+		// default route below.
+	case first[0] == 0x16:
+		peeked := peekIncremental(conn.br, func(b []byte) bool {
+			_, _, err := parseClientHelloSNI(b)
+			return err == nil
+		})
+		sniHost, alpns, _ = parseClientHelloSNI(peeked)
+	default:
+		peeked := peekIncremental(conn.br, func(b []byte) bool {
+			return parseHTTPHost(b) != ""
+		})
+		httpHost = parseHTTPHost(peeked)
+	}
+
+	target, ok := matchTargetRoute(service.Targets, sniHost, httpHost, alpns)
+	if !ok {
+		return "", conn, fmt.Errorf("service %s: no matching target route and no default defined", service.Name)
+	}
+	return target, conn, nil
+}
+
+// peekIncremental grows the peeked window starting from whatever is
+// already buffered (a ClientHello or HTTP request line almost always
+// arrives in a single flight, so this is usually enough on the first
+// try) and doubles it until tryParse succeeds or the window reaches
+// peekBufferSize. This avoids forcing bufio.Reader to block filling the
+// entire peekBufferSize, which would otherwise stall every connection
+// until peekDeadline since the client sends nothing further until it
+// hears back from the (not yet dialed) backend.
+func peekIncremental(br *bufio.Reader, tryParse func([]byte) bool) []byte {
+	size := br.Buffered()
+	if size == 0 {
+		size = 512
+	}
+	for {
+		if size > peekBufferSize {
+			size = peekBufferSize
+		}
+		data, _ := br.Peek(size)
+		if tryParse(data) || size >= peekBufferSize {
+			return data
+		}
+		size *= 2
+	}
+}
+
+// describeTargets renders a service's dial target(s) for log output.
+func describeTargets(service *ServiceConfig) string {
+	if len(service.Targets) == 0 {
+		return service.Target
+	}
+	parts := make([]string, 0, len(service.Targets))
+	for _, route := range service.Targets {
+		if route.Match == matchDefault {
+			parts = append(parts, fmt.Sprintf("default=%s", route.Target))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s=%s", route.Match, route.Value, route.Target))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func matchTargetRoute(routes []TargetRoute, sniHost, httpHost string, alpns []string) (string, bool) {
+	var defaultTarget string
+	haveDefault := false
+
+	for _, route := range routes {
+		switch route.Match {
+		case matchSNIHost:
+			if sniHost != "" && strings.EqualFold(sniHost, route.Value) {
+				return route.Target, true
+			}
+		case matchHTTPHost:
+			if httpHost != "" && strings.EqualFold(httpHost, route.Value) {
+				return route.Target, true
+			}
+		case matchALPN:
+			for _, proto := range alpns {
+				if strings.EqualFold(proto, route.Value) {
+					return route.Target, true
+				}
+			}
+		case matchDefault:
+			defaultTarget = route.Target
+			haveDefault = true
+		}
+	}
+
+	return defaultTarget, haveDefault
+}
+
+// parseHTTPHost scans a (possibly truncated) plaintext HTTP/1.1 request
+// for its Host header, returning the hostname without a port. It is
+// best-effort: any parse failure just yields "".
+func parseHTTPHost(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		if len(line) == 0 {
+			break
+		}
+		const prefix = "host:"
+		if len(line) <= len(prefix) {
+			continue
+		}
+		if !strings.EqualFold(string(line[:len(prefix)]), prefix) {
+			continue
+		}
+		host := strings.TrimSpace(string(line[len(prefix):]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			return h
+		}
+		return host
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+func TestParseHTTPHost(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "basic host header",
+			data: "GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl\r\n\r\n",
+			want: "example.com",
+		},
+		{
+			name: "host header with port is stripped",
+			data: "GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+			want: "example.com",
+		},
+		{
+			name: "header name is case-insensitive",
+			data: "GET / HTTP/1.1\r\nhOsT: example.com\r\n\r\n",
+			want: "example.com",
+		},
+		{
+			name: "no host header",
+			data: "GET / HTTP/1.1\r\nUser-Agent: curl\r\n\r\n",
+			want: "",
+		},
+		{
+			name: "host line itself cut short",
+			data: "GET / HTTP/1.1\r\nHos",
+			want: "",
+		},
+		{
+			name: "not HTTP at all",
+			data: "\x16\x03\x01\x00\x05",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseHTTPHost([]byte(tt.data)); got != tt.want {
+				t.Errorf("parseHTTPHost(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTargetRoute(t *testing.T) {
+	routes := []TargetRoute{
+		{Match: matchSNIHost, Value: "a.example.com", Target: "127.0.0.1:9001"},
+		{Match: matchHTTPHost, Value: "b.example.com", Target: "127.0.0.1:9002"},
+		{Match: matchALPN, Value: "h2", Target: "127.0.0.1:9003"},
+		{Match: matchDefault, Target: "127.0.0.1:9000"},
+	}
+
+	tests := []struct {
+		name       string
+		sniHost    string
+		httpHost   string
+		alpns      []string
+		wantTarget string
+		wantOK     bool
+	}{
+		{
+			name:       "sni host match",
+			sniHost:    "A.Example.Com",
+			wantTarget: "127.0.0.1:9001",
+			wantOK:     true,
+		},
+		{
+			name:       "http host match",
+			httpHost:   "b.example.com",
+			wantTarget: "127.0.0.1:9002",
+			wantOK:     true,
+		},
+		{
+			name:       "alpn match",
+			alpns:      []string{"http/1.1", "h2"},
+			wantTarget: "127.0.0.1:9003",
+			wantOK:     true,
+		},
+		{
+			name:       "no match falls back to default",
+			sniHost:    "nobody.example.com",
+			wantTarget: "127.0.0.1:9000",
+			wantOK:     true,
+		},
+		{
+			name:       "no match and no default",
+			sniHost:    "nobody.example.com",
+			wantTarget: "",
+			wantOK:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := routes
+			if !tt.wantOK && tt.wantTarget == "" {
+				rs = routes[:3] // no default entry
+			}
+			target, ok := matchTargetRoute(rs, tt.sniHost, tt.httpHost, tt.alpns)
+			if ok != tt.wantOK || target != tt.wantTarget {
+				t.Errorf("matchTargetRoute() = (%q, %v), want (%q, %v)", target, ok, tt.wantTarget, tt.wantOK)
+			}
+		})
+	}
+}
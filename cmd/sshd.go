@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/rs/zerolog/log"
+	gossh "golang.org/x/crypto/ssh"
+	"gosuda.org/portal/utils"
+)
+
+// commandGraceWindow bounds how long the "tcpip-forward" request handler
+// waits for the session's exec command (which carries --name and the
+// protocol) before falling back to an auto-generated name. The two
+// arrive as independent SSH messages with no guaranteed order.
+const commandGraceWindow = 500 * time.Millisecond
+
+// connState tracks the per-SSH-connection info needed to turn an
+// `ssh -R` forward request into a named portal-tunnel service: the
+// username bound to the client's authenticated key (not the username it
+// claimed when connecting) and whatever the session's exec command
+// specified.
+type connState struct {
+	mu        sync.Mutex
+	username  string
+	name      string
+	protocols []string
+	cmdReady  chan struct{}
+	cmdOnce   sync.Once
+}
+
+func newConnState(username string) *connState {
+	return &connState{username: username, cmdReady: make(chan struct{})}
+}
+
+var connStateKey = struct{ name string }{"portal-tunnel-conn-state"}
+
+// authorizedUserKey holds the username PublicKeyHandler bound to the
+// client's authenticated key, set before any session or forward request
+// is handled. connState must use this instead of ctx.User(), which is
+// just the client-claimed username and unchecked.
+var authorizedUserKey = struct{ name string }{"portal-tunnel-authorized-user"}
+
+func getConnState(ctx ssh.Context) *connState {
+	ctx.Lock()
+	defer ctx.Unlock()
+	if st, ok := ctx.Value(connStateKey).(*connState); ok {
+		return st
+	}
+	username, _ := ctx.Value(authorizedUserKey).(string)
+	st := newConnState(username)
+	ctx.SetValue(connStateKey, st)
+	return st
+}
+
+// runSSHD starts the embedded SSH server that accepts `ssh -R` reverse
+// forwards and exposes each one as a portal-tunnel service, without the
+// caller needing to run portal-tunnel locally themselves.
+func runSSHD() error {
+	if strings.TrimSpace(flagSSHDAuthorizedKeys) == "" {
+		return fmt.Errorf("--authorized-keys is required")
+	}
+	authorizedKeys, err := loadAuthorizedKeys(flagSSHDAuthorizedKeys)
+	if err != nil {
+		return fmt.Errorf("load authorized keys: %w", err)
+	}
+
+	relayURLs := utils.ParseURLs(flagRelayURLs)
+	if len(relayURLs) == 0 {
+		return fmt.Errorf("--relay must include at least one non-empty URL")
+	}
+	relayDir := NewRelayDirectory([]RelayConfig{{Name: "sshd", URLs: relayURLs}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	forwards := newForwardTable()
+
+	server := &ssh.Server{
+		Addr: flagSSHDAddr,
+		PublicKeyHandler: func(sshCtx ssh.Context, key ssh.PublicKey) bool {
+			username, ok := matchAuthorizedKey(authorizedKeys, key)
+			if !ok {
+				return false
+			}
+			sshCtx.Lock()
+			sshCtx.SetValue(authorizedUserKey, username)
+			sshCtx.Unlock()
+			return true
+		},
+		Handler: func(s ssh.Session) {
+			handleSession(s)
+		},
+		RequestHandlers: map[string]ssh.RequestHandler{
+			"tcpip-forward":        forwards.handleForwardRequest(ctx, relayDir),
+			"cancel-tcpip-forward": forwards.handleCancelRequest(),
+		},
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session": ssh.DefaultSessionHandler,
+		},
+	}
+
+	if flagSSHDHostKey != "" {
+		if err := server.SetOption(ssh.HostKeyFile(flagSSHDHostKey)); err != nil {
+			return fmt.Errorf("load host key: %w", err)
+		}
+	} else {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("generate ephemeral host key: %w", err)
+		}
+		signer, err := gossh.NewSignerFromKey(key)
+		if err != nil {
+			return fmt.Errorf("wrap ephemeral host key: %w", err)
+		}
+		server.AddHostKey(signer)
+	}
+
+	log.Info().Str("addr", flagSSHDAddr).Msg("Starting portal-tunnel sshd")
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.ListenAndServe() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return server.Close()
+	}
+}
+
+// handleSession parses the exec command string (e.g. "tcp --name myapp")
+// and publishes it into the connection's shared state so pending and
+// future tcpip-forward requests on this connection pick it up.
+func handleSession(s ssh.Session) {
+	st := getConnState(s.Context())
+
+	args := s.Command()
+	protocol := "tcp"
+	name := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case i == 0 && !strings.HasPrefix(args[i], "--"):
+			protocol = args[i]
+		case args[i] == "--name" && i+1 < len(args):
+			name = args[i+1]
+			i++
+		}
+	}
+
+	st.mu.Lock()
+	st.name = name
+	st.protocols = protocolsForAlias(protocol)
+	st.mu.Unlock()
+	st.cmdOnce.Do(func() { close(st.cmdReady) })
+
+	fmt.Fprintf(s, "portal-tunnel: forwarding active for %s\n", s.User())
+	<-s.Context().Done()
+}
+
+func protocolsForAlias(alias string) []string {
+	switch strings.ToLower(strings.TrimSpace(alias)) {
+	case "http":
+		return []string{"http/1.1", "h2"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// authorizedKeyEntry binds one authorized public key to the only
+// username it may claim, so a connecting client cannot pick its own
+// service-name namespace by presenting `ssh -R` as an arbitrary user.
+type authorizedKeyEntry struct {
+	username string
+	key      ssh.PublicKey
+}
+
+// loadAuthorizedKeys reads an authorized_keys-formatted file, requiring
+// each line's trailing comment field to name the username that key is
+// allowed to claim, e.g. "ssh-ed25519 AAAA... alice".
+func loadAuthorizedKeys(path string) ([]authorizedKeyEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []authorizedKeyEntry
+	rest := data
+	for len(rest) > 0 {
+		pk, comment, _, remainder, err := gossh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		username := strings.TrimSpace(comment)
+		if username == "" {
+			return nil, fmt.Errorf("authorized key missing username comment (expected \"<type> <key> <username>\")")
+		}
+		entries = append(entries, authorizedKeyEntry{username: username, key: pk})
+		rest = remainder
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return entries, nil
+}
+
+// matchAuthorizedKey returns the username bound to candidate, if any
+// authorized entry matches it.
+func matchAuthorizedKey(authorized []authorizedKeyEntry, candidate ssh.PublicKey) (string, bool) {
+	for _, entry := range authorized {
+		if ssh.KeysEqual(entry.key, candidate) {
+			return entry.username, true
+		}
+	}
+	return "", false
+}
+
+// forwardTable tracks in-flight service tunnels started on behalf of
+// `tcpip-forward` requests so `cancel-tcpip-forward` can tear them down.
+type forwardTable struct {
+	mu     sync.Mutex
+	active map[string]context.CancelFunc
+}
+
+func newForwardTable() *forwardTable {
+	return &forwardTable{active: make(map[string]context.CancelFunc)}
+}
+
+type tcpipForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type tcpipForwardReply struct {
+	BoundPort uint32
+}
+
+func (t *forwardTable) handleForwardRequest(parent context.Context, relayDir *RelayDirectory) ssh.RequestHandler {
+	return func(sshCtx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		var payload tcpipForwardPayload
+		if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+			return false, nil
+		}
+
+		sshConn, ok := sshCtx.Value(ssh.ContextKeyConn).(gossh.Conn)
+		if !ok {
+			return false, nil
+		}
+
+		st := getConnState(sshCtx)
+
+		fwdCtx, cancel := context.WithCancel(parent)
+		key := fmt.Sprintf("%s/%s:%d", sshCtx.SessionID(), payload.BindAddr, payload.BindPort)
+		t.mu.Lock()
+		t.active[key] = cancel
+		t.mu.Unlock()
+
+		go func() {
+			select {
+			case <-st.cmdReady:
+			case <-time.After(commandGraceWindow):
+			}
+
+			st.mu.Lock()
+			name := st.name
+			protocols := st.protocols
+			st.mu.Unlock()
+			if name == "" {
+				name = fmt.Sprintf("fwd-%d", payload.BindPort)
+			}
+			if len(protocols) == 0 {
+				protocols = []string{"tcp"}
+			}
+
+			if err := runForwardedService(fwdCtx, relayDir, sshConn, st.username, name, protocols, payload); err != nil && fwdCtx.Err() == nil {
+				log.Error().Str("user", st.username).Err(err).Msg("Forwarded service exited with error")
+			}
+		}()
+
+		go func() {
+			<-sshCtx.Done()
+			cancel()
+		}()
+
+		if payload.BindPort != 0 {
+			return true, nil
+		}
+		reply := tcpipForwardReply{BoundPort: payload.BindPort}
+		return true, gossh.Marshal(&reply)
+	}
+}
+
+func (t *forwardTable) handleCancelRequest() ssh.RequestHandler {
+	return func(sshCtx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+		var payload tcpipForwardPayload
+		if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+			return false, nil
+		}
+
+		key := fmt.Sprintf("%s/%s:%d", sshCtx.SessionID(), payload.BindAddr, payload.BindPort)
+		t.mu.Lock()
+		cancel, ok := t.active[key]
+		delete(t.active, key)
+		t.mu.Unlock()
+		if ok {
+			cancel()
+		}
+		return true, nil
+	}
+}
+
+// runForwardedService synthesizes a ServiceConfig for one `ssh -R`
+// forward and runs it through the normal runServiceTunnel path, with a
+// dialer that reaches the "local" side by opening a forwarded-tcpip
+// channel back through the SSH connection instead of dialing TCP.
+func runForwardedService(ctx context.Context, relayDir *RelayDirectory, sshConn gossh.Conn, username, name string, protocols []string, fwd tcpipForwardPayload) error {
+	serviceName := fmt.Sprintf("%s-%s", sanitizeName(username), sanitizeName(name))
+	service := &ServiceConfig{
+		Name:            serviceName,
+		Target:          fmt.Sprintf("ssh-client:%s (bind %s:%d)", username, fwd.BindAddr, fwd.BindPort),
+		RelayPreference: []string{"sshd"},
+		Protocols:       protocols,
+	}
+	applyServiceDefaults(service)
+
+	dial := func(ctx context.Context, _ string, remoteAddr net.Addr) (net.Conn, error) {
+		return openForwardedChannel(ctx, sshConn, fwd, remoteAddr)
+	}
+
+	return runServiceTunnel(ctx, relayDir, service, fmt.Sprintf("sshd user=%s", username), dial)
+}
+
+func sanitizeName(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
+	if s == "" {
+		return "svc"
+	}
+	return s
+}
+
+type forwardedTCPChannelPayload struct {
+	ConnectedAddr  string
+	ConnectedPort  uint32
+	OriginatorAddr string
+	OriginatorPort uint32
+}
+
+// openForwardedChannel opens a forwarded-tcpip channel on sshConn,
+// mirroring what a real listener Accept would have produced for an
+// ordinary `ssh -R` forward, and adapts it to a net.Conn.
+func openForwardedChannel(ctx context.Context, sshConn gossh.Conn, fwd tcpipForwardPayload, remoteAddr net.Addr) (net.Conn, error) {
+	originHost, originPort := splitHostPortOrZero(remoteAddr)
+
+	payload := forwardedTCPChannelPayload{
+		ConnectedAddr:  fwd.BindAddr,
+		ConnectedPort:  fwd.BindPort,
+		OriginatorAddr: originHost,
+		OriginatorPort: originPort,
+	}
+
+	channel, reqs, err := sshConn.OpenChannel("forwarded-tcpip", gossh.Marshal(&payload))
+	if err != nil {
+		return nil, fmt.Errorf("open forwarded-tcpip channel: %w", err)
+	}
+	go gossh.DiscardRequests(reqs)
+
+	return &channelConn{Channel: channel, local: remoteAddr}, nil
+}
+
+func splitHostPortOrZero(addr net.Addr) (string, uint32) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String(), uint32(tcpAddr.Port)
+	}
+	return "0.0.0.0", 0
+}
+
+// channelConn adapts an SSH channel to net.Conn so it can be used
+// anywhere proxyConnection expects a local connection. Deadlines are
+// not supported by SSH channels and are accepted as no-ops.
+type channelConn struct {
+	gossh.Channel
+	local net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr                { return c.local }
+func (c *channelConn) RemoteAddr() net.Addr               { return c.local }
+func (c *channelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,193 @@
+package main
+
+import "fmt"
+
+// TLS extension numbers we care about (RFC 8446).
+const (
+	extServerName = 0
+	extALPN       = 16
+)
+
+// parseClientHelloSNI extracts the SNI hostname and ALPN protocol list
+// from a (possibly truncated) TLS record containing a ClientHello, by
+// hand-walking the handshake structure. It never terminates the TLS
+// connection; it only reads what the relay-side caller has already
+// peeked. Returns an error if record is too short or malformed.
+func parseClientHelloSNI(record []byte) (sni string, alpns []string, err error) {
+	// TLS record header: type(1) version(2) length(2).
+	if len(record) < 5 || record[0] != 0x16 {
+		return "", nil, fmt.Errorf("not a TLS handshake record")
+	}
+	recLen := int(record[3])<<8 | int(record[4])
+	end := 5 + recLen
+	if end > len(record) {
+		end = len(record) // record is truncated; parse as much as we have
+	}
+	hs := record[5:end]
+
+	// Handshake header: msgType(1) length(3).
+	if len(hs) < 4 || hs[0] != 0x01 {
+		return "", nil, fmt.Errorf("not a ClientHello")
+	}
+	body := hs[4:]
+
+	r := &byteReader{b: body}
+
+	r.skip(2)  // client_version
+	r.skip(32) // random
+
+	sessionIDLen, ok := r.readUint8()
+	if !ok {
+		return "", nil, fmt.Errorf("truncated session id length")
+	}
+	r.skip(int(sessionIDLen))
+
+	cipherSuitesLen, ok := r.readUint16()
+	if !ok {
+		return "", nil, fmt.Errorf("truncated cipher suites length")
+	}
+	r.skip(int(cipherSuitesLen))
+
+	compressionLen, ok := r.readUint8()
+	if !ok {
+		return "", nil, fmt.Errorf("truncated compression methods length")
+	}
+	r.skip(int(compressionLen))
+
+	if r.remaining() < 2 {
+		// No extensions present; not an error, just nothing to match on.
+		return "", nil, nil
+	}
+	extensionsLen, ok := r.readUint16()
+	if !ok {
+		return "", nil, fmt.Errorf("truncated extensions length")
+	}
+	extensions, ok := r.readBytes(int(extensionsLen))
+	if !ok {
+		extensions = r.remainingBytes() // best-effort on truncated data
+	}
+
+	er := &byteReader{b: extensions}
+	for er.remaining() >= 4 {
+		extType, _ := er.readUint16()
+		extLen, _ := er.readUint16()
+		extData, ok := er.readBytes(int(extLen))
+		if !ok {
+			break
+		}
+
+		switch extType {
+		case extServerName:
+			if host, ok := parseServerNameExtension(extData); ok {
+				sni = host
+			}
+		case extALPN:
+			alpns = parseALPNExtension(extData)
+		}
+	}
+
+	return sni, alpns, nil
+}
+
+func parseServerNameExtension(data []byte) (string, bool) {
+	r := &byteReader{b: data}
+	listLen, ok := r.readUint16()
+	if !ok {
+		return "", false
+	}
+	list, ok := r.readBytes(int(listLen))
+	if !ok {
+		return "", false
+	}
+
+	lr := &byteReader{b: list}
+	for lr.remaining() >= 3 {
+		nameType, _ := lr.readUint8()
+		nameLen, _ := lr.readUint16()
+		name, ok := lr.readBytes(int(nameLen))
+		if !ok {
+			break
+		}
+		if nameType == 0 { // host_name
+			return string(name), true
+		}
+	}
+	return "", false
+}
+
+func parseALPNExtension(data []byte) []string {
+	r := &byteReader{b: data}
+	listLen, ok := r.readUint16()
+	if !ok {
+		return nil
+	}
+	list, ok := r.readBytes(int(listLen))
+	if !ok {
+		return nil
+	}
+
+	var protos []string
+	lr := &byteReader{b: list}
+	for lr.remaining() >= 1 {
+		n, ok := lr.readUint8()
+		if !ok {
+			break
+		}
+		proto, ok := lr.readBytes(int(n))
+		if !ok {
+			break
+		}
+		protos = append(protos, string(proto))
+	}
+	return protos
+}
+
+// byteReader is a tiny bounds-checked cursor over a byte slice, used to
+// hand-walk the TLS ClientHello structure without pulling in a full TLS
+// parser.
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *byteReader) remaining() int {
+	return len(r.b) - r.pos
+}
+
+func (r *byteReader) remainingBytes() []byte {
+	return r.b[r.pos:]
+}
+
+func (r *byteReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.b) {
+		r.pos = len(r.b)
+	}
+}
+
+func (r *byteReader) readUint8() (uint8, bool) {
+	if r.remaining() < 1 {
+		return 0, false
+	}
+	v := r.b[r.pos]
+	r.pos++
+	return v, true
+}
+
+func (r *byteReader) readUint16() (uint16, bool) {
+	if r.remaining() < 2 {
+		return 0, false
+	}
+	v := uint16(r.b[r.pos])<<8 | uint16(r.b[r.pos+1])
+	r.pos += 2
+	return v, true
+}
+
+func (r *byteReader) readBytes(n int) ([]byte, bool) {
+	if n < 0 || r.remaining() < n {
+		return nil, false
+	}
+	v := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return v, true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// buildClientHello assembles a minimal well-formed TLS 1.2 ClientHello
+// record carrying the given SNI hostname and ALPN protocol list, for
+// feeding to parseClientHelloSNI in tests. Either argument may be empty
+// to omit that extension.
+func buildClientHello(sni string, alpns []string) []byte {
+	var extensions []byte
+
+	if sni != "" {
+		name := []byte(sni)
+		serverName := append([]byte{0x00}, uint16Bytes(uint16(len(name)))...)
+		serverName = append(serverName, name...)
+		list := append(uint16Bytes(uint16(len(serverName))), serverName...)
+		extensions = append(extensions, uint16Bytes(extServerName)...)
+		extensions = append(extensions, uint16Bytes(uint16(len(list)))...)
+		extensions = append(extensions, list...)
+	}
+
+	if len(alpns) > 0 {
+		var list []byte
+		for _, proto := range alpns {
+			list = append(list, byte(len(proto)))
+			list = append(list, []byte(proto)...)
+		}
+		data := append(uint16Bytes(uint16(len(list))), list...)
+		extensions = append(extensions, uint16Bytes(extALPN)...)
+		extensions = append(extensions, uint16Bytes(uint16(len(data)))...)
+		extensions = append(extensions, data...)
+	}
+
+	body := []byte{}
+	body = append(body, 0x03, 0x03)          // client_version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0x00)                // session id length
+	body = append(body, 0x00, 0x02)          // cipher suites length
+	body = append(body, 0x00, 0x00)          // one cipher suite
+	body = append(body, 0x01, 0x00)          // compression methods
+	body = append(body, uint16Bytes(uint16(len(extensions)))...)
+	body = append(body, extensions...)
+
+	hs := append([]byte{0x01}, uint24Bytes(len(body))...)
+	hs = append(hs, body...)
+
+	record := []byte{0x16, 0x03, 0x01}
+	record = append(record, uint16Bytes(uint16(len(hs)))...)
+	record = append(record, hs...)
+	return record
+}
+
+func uint16Bytes(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func uint24Bytes(v int) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func TestParseClientHelloSNI(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    []byte
+		wantSNI   string
+		wantALPNs []string
+		wantErr   bool
+	}{
+		{
+			name:      "sni and alpn present",
+			record:    buildClientHello("example.com", []string{"h2", "http/1.1"}),
+			wantSNI:   "example.com",
+			wantALPNs: []string{"h2", "http/1.1"},
+		},
+		{
+			name:    "sni only",
+			record:  buildClientHello("example.com", nil),
+			wantSNI: "example.com",
+		},
+		{
+			name:   "no extensions",
+			record: buildClientHello("", nil),
+		},
+		{
+			name:    "not a TLS handshake record",
+			record:  []byte{0x17, 0x03, 0x01, 0x00, 0x01, 0x00},
+			wantErr: true,
+		},
+		{
+			name:    "too short to have a header",
+			record:  []byte{0x16, 0x03},
+			wantErr: true,
+		},
+		{
+			name:    "handshake header is not a ClientHello",
+			record:  []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x02, 0x00, 0x00, 0x00},
+			wantErr: true,
+		},
+		{
+			name:    "ClientHello truncated before session id is rejected",
+			record:  buildClientHello("example.com", []string{"h2"})[:20],
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sni, alpns, err := parseClientHelloSNI(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClientHelloSNI() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sni != tt.wantSNI {
+				t.Errorf("sni = %q, want %q", sni, tt.wantSNI)
+			}
+			if !reflect.DeepEqual(alpns, tt.wantALPNs) {
+				t.Errorf("alpns = %v, want %v", alpns, tt.wantALPNs)
+			}
+		})
+	}
+}